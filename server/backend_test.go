@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+	influxdb2write "github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeBackend is a minimal influxBackend used to exercise Influx's batching,
+// retry and chunking logic without talking to a real InfluxDB server
+type fakeBackend struct {
+	writes     [][]*influxdb.Point
+	failWrites int // number of leading WritePoints calls that should fail
+}
+
+func (b *fakeBackend) WritePoints(points []*influxdb.Point) error {
+	b.writes = append(b.writes, points)
+	if len(b.writes) <= b.failWrites {
+		return errors.New("fake write failure")
+	}
+	return nil
+}
+
+func (b *fakeBackend) Ping() error  { return nil }
+func (b *fakeBackend) Close() error { return nil }
+
+func newTestInflux(backend influxBackend) *Influx {
+	return &Influx{
+		backend:      backend,
+		pointExpiry:  defaultPointExpiry,
+		maxQueueSize: defaultMaxQueueSize,
+		batchMaxSize: defaultBatchMaxSize,
+		flush:        make(chan struct{}, 1),
+	}
+}
+
+func TestWriteBatchPointsRetriesOnFailure(t *testing.T) {
+	backend := &fakeBackend{failWrites: 1}
+	m := newTestInflux(backend)
+	m.points = []*influxdb.Point{newTestPoint(t, time.Now())}
+
+	before := testutil.ToFloat64(influxWriteFailures)
+	m.writeBatchPoints()
+
+	if len(backend.writes) != 1 {
+		t.Fatalf("expected one write attempt, got %d", len(backend.writes))
+	}
+	if len(m.points) != 1 {
+		t.Fatalf("expected the failed point to be re-queued, got %d points", len(m.points))
+	}
+	if got := testutil.ToFloat64(influxWriteFailures); got != before+1 {
+		t.Errorf("expected influxWriteFailures to increment by 1, got delta %v", got-before)
+	}
+
+	// retry succeeds
+	beforeWritten := testutil.ToFloat64(influxPointsWritten)
+	m.writeBatchPoints()
+
+	if len(m.points) != 0 {
+		t.Errorf("expected the queue to drain after a successful retry, got %d points", len(m.points))
+	}
+	if got := testutil.ToFloat64(influxPointsWritten); got != beforeWritten+1 {
+		t.Errorf("expected influxPointsWritten to increment by 1, got delta %v", got-beforeWritten)
+	}
+}
+
+func TestWriteBatchPointsSplitsIntoChunks(t *testing.T) {
+	backend := &fakeBackend{}
+	m := newTestInflux(backend)
+	m.batchMaxSize = 2
+	for i := 0; i < 5; i++ {
+		m.points = append(m.points, newTestPoint(t, time.Now()))
+	}
+
+	m.writeBatchPoints()
+
+	if len(backend.writes) != 3 {
+		t.Fatalf("expected 3 chunked writes for 5 points at batchMaxSize 2, got %d", len(backend.writes))
+	}
+	if len(backend.writes[0]) != 2 || len(backend.writes[1]) != 2 || len(backend.writes[2]) != 1 {
+		t.Errorf("unexpected chunk sizes: %v", backend.writes)
+	}
+}
+
+// fakeWriteAPI is a minimal influxdb2api.WriteAPIBlocking used to inspect
+// what v2Backend.WritePoints converts v1 points into
+type fakeWriteAPI struct {
+	written []*influxdb2write.Point
+}
+
+func (f *fakeWriteAPI) WriteRecord(ctx context.Context, line ...string) error { return nil }
+
+func (f *fakeWriteAPI) EncodePoints(point ...*influxdb2write.Point) (string, error) {
+	return "", nil
+}
+
+func (f *fakeWriteAPI) WritePoint(ctx context.Context, point ...*influxdb2write.Point) error {
+	f.written = append(f.written, point...)
+	return nil
+}
+
+func TestV2BackendWritePointsConvertsPoints(t *testing.T) {
+	writeAPI := &fakeWriteAPI{}
+	backend := &v2Backend{writeAPI: writeAPI}
+
+	now := time.Now()
+	p, err := influxdb.NewPoint("snips", map[string]string{"device": "SDM1.1"}, map[string]interface{}{"value": 42.0}, now)
+	if err != nil {
+		t.Fatalf("failed creating test point: %v", err)
+	}
+
+	if err := backend.WritePoints([]*influxdb.Point{p}); err != nil {
+		t.Fatalf("WritePoints returned error: %v", err)
+	}
+
+	if len(writeAPI.written) != 1 {
+		t.Fatalf("expected 1 converted point, got %d", len(writeAPI.written))
+	}
+	if got := writeAPI.written[0].Name(); got != "snips" {
+		t.Errorf("expected converted point name %q, got %q", "snips", got)
+	}
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+func TestChunkPoints(t *testing.T) {
+	points := make([]*influxdb.Point, 5)
+	for i := range points {
+		points[i] = newTestPoint(t, time.Now())
+	}
+
+	chunks := chunkPoints(points, 2)
+
+	if len(chunks) != 3 {
+		t.Fatalf("expected 3 chunks, got %d", len(chunks))
+	}
+	if len(chunks[0]) != 2 || len(chunks[1]) != 2 || len(chunks[2]) != 1 {
+		t.Errorf("unexpected chunk sizes: %v", chunks)
+	}
+}
+
+func TestChunkPointsSizeDisabledOrLarger(t *testing.T) {
+	points := make([]*influxdb.Point, 3)
+	for i := range points {
+		points[i] = newTestPoint(t, time.Now())
+	}
+
+	if chunks := chunkPoints(points, 0); len(chunks) != 1 || len(chunks[0]) != 3 {
+		t.Errorf("expected size<=0 to return a single chunk, got %v", chunks)
+	}
+	if chunks := chunkPoints(points, 10); len(chunks) != 1 || len(chunks[0]) != 3 {
+		t.Errorf("expected a size larger than the input to return a single chunk, got %v", chunks)
+	}
+}
+
+func TestEnforceMaxQueueSizeSignalsFlushWhenFull(t *testing.T) {
+	m := &Influx{
+		batchMaxSize: 2,
+		flush:        make(chan struct{}, 1),
+		points:       []*influxdb.Point{newTestPoint(t, time.Now()), newTestPoint(t, time.Now())},
+	}
+
+	m.enforceMaxQueueSize()
+
+	select {
+	case <-m.flush:
+	default:
+		t.Error("expected a flush signal once the queue reached batchMaxSize")
+	}
+}
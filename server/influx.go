@@ -1,29 +1,85 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"log"
+	"strings"
 	"sync"
 	"time"
 
 	influxdb "github.com/influxdata/influxdb1-client/v2"
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	influxdb2api "github.com/influxdata/influxdb-client-go/v2/api"
+	influxdb2write "github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
+var (
+	influxPointsWritten = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "influx_points_written_total",
+		Help: "Total number of points successfully written to InfluxDB",
+	})
+	influxWriteFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "influx_write_failures_total",
+		Help: "Total number of failed InfluxDB batch writes",
+	})
+	influxQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "influx_queue_depth",
+		Help: "Current number of points queued for writing to InfluxDB",
+	})
+)
+
+// phaseSuffixes are the per-line measurement suffixes that get split out
+// into their own "phase" tag instead of staying baked into the type tag
+var phaseSuffixes = []string{"L1", "L2", "L3"}
+
+// errPingFailed is returned when an InfluxDB 2.x health check reports unhealthy
+var errPingFailed = errors.New("influx: ping failed")
+
 const (
 	writeTimeout = 30 * time.Second
+
+	// defaultPointExpiry discards points that could not be written after
+	// they have been sitting in the retry queue for this long
+	defaultPointExpiry = 10 * time.Minute
+
+	// defaultMaxQueueSize is the upper bound on the number of points kept
+	// around for retry before the oldest ones are dropped
+	defaultMaxQueueSize = 100000
+
+	// defaultBatchMaxSize triggers an immediate flush once this many points
+	// are queued, instead of waiting for the next interval tick
+	defaultBatchMaxSize = 1000
 )
 
+// influxBackend abstracts the wire-level differences between InfluxDB 1.x's
+// database/user/password HTTP API and InfluxDB 2.x's org/bucket/token Flux
+// API, so the batching and retry logic in Influx can stay version-agnostic
+type influxBackend interface {
+	WritePoints(points []*influxdb.Point) error
+	Ping() error
+	Close() error
+}
+
 // Influx is a influx publisher
 type Influx struct {
 	sync.Mutex
-	client      influxdb.Client
-	points      []*influxdb.Point
-	pointsConf  influxdb.BatchPointsConfig
-	interval    time.Duration
-	measurement string
-	verbose     bool
+	backend      influxBackend
+	points       []*influxdb.Point
+	interval     time.Duration
+	measurement  string
+	verbose      bool
+	pointExpiry  time.Duration
+	maxQueueSize int
+	batchMaxSize int
+	flush        chan struct{}
+	transformer  *Transformer
+	tags         map[string]string
 }
 
-// NewInfluxClient creates new publisher for influx
+// NewInfluxClient creates new publisher for influxdb 1.x
 func NewInfluxClient(
 	url string,
 	database string,
@@ -33,7 +89,76 @@ func NewInfluxClient(
 	user string,
 	password string,
 	verbose bool,
+	retentionPolicy string,
 ) *Influx {
+	if database == "" {
+		log.Fatal("influx: missing database")
+	}
+	if measurement == "" {
+		log.Fatal("influx: missing measurement")
+	}
+
+	backend := newV1Backend(url, database, retentionPolicy, precision, user, password)
+
+	return newInflux(backend, measurement, interval, verbose)
+}
+
+// NewInfluxV2Client creates new publisher for influxdb 2.x / Flux
+func NewInfluxV2Client(
+	url string,
+	org string,
+	bucket string,
+	token string,
+	measurement string,
+	precision string,
+	interval time.Duration,
+	verbose bool,
+) *Influx {
+	if org == "" {
+		log.Fatal("influx: missing org")
+	}
+	if bucket == "" {
+		log.Fatal("influx: missing bucket")
+	}
+	if measurement == "" {
+		log.Fatal("influx: missing measurement")
+	}
+
+	backend := newV2Backend(url, org, bucket, token, precision)
+
+	return newInflux(backend, measurement, interval, verbose)
+}
+
+// newInflux wires up an Influx publisher around an already-constructed backend
+func newInflux(backend influxBackend, measurement string, interval time.Duration, verbose bool) *Influx {
+	// check connection in the background so a transient outage at boot
+	// (e.g. Influx still starting up alongside us) doesn't kill the process;
+	// writes simply queue and retry until the backend becomes reachable
+	go func(backend influxBackend) {
+		if err := backend.Ping(); err != nil {
+			log.Printf("influx: %s", err)
+		}
+	}(backend)
+
+	return &Influx{
+		backend:      backend,
+		interval:     interval,
+		measurement:  measurement,
+		verbose:      verbose,
+		pointExpiry:  defaultPointExpiry,
+		maxQueueSize: defaultMaxQueueSize,
+		batchMaxSize: defaultBatchMaxSize,
+		flush:        make(chan struct{}, 1),
+	}
+}
+
+// v1Backend talks to InfluxDB 1.x via the influxdb1-client HTTP API
+type v1Backend struct {
+	client influxdb.Client
+	conf   influxdb.BatchPointsConfig
+}
+
+func newV1Backend(url, database, retentionPolicy, precision, user, password string) *v1Backend {
 	client, err := influxdb.NewHTTPClient(influxdb.HTTPConfig{
 		Addr:     url,
 		Username: user,
@@ -44,30 +169,168 @@ func NewInfluxClient(
 		log.Fatalf("influx: error creating client: %v", err)
 	}
 
-	if database == "" {
-		log.Fatal("influx: missing database")
+	return &v1Backend{
+		client: client,
+		conf: influxdb.BatchPointsConfig{
+			Database:        database,
+			RetentionPolicy: retentionPolicy,
+			Precision:       precision,
+		},
 	}
-	if measurement == "" {
-		log.Fatal("influx: missing measurement")
+}
+
+func (b *v1Backend) WritePoints(points []*influxdb.Point) error {
+	batch, err := influxdb.NewBatchPoints(b.conf)
+	if err != nil {
+		return err
+	}
+
+	batch.AddPoints(points)
+	return b.client.Write(batch)
+}
+
+func (b *v1Backend) Ping() error {
+	_, _, err := b.client.Ping(writeTimeout)
+	return err
+}
+
+func (b *v1Backend) Close() error {
+	return b.client.Close()
+}
+
+// v2Backend talks to InfluxDB 2.x via the influxdb-client-go Flux API
+type v2Backend struct {
+	client   influxdb2.Client
+	writeAPI influxdb2api.WriteAPIBlocking
+}
+
+func newV2Backend(url, org, bucket, token, precision string) *v2Backend {
+	options := influxdb2.DefaultOptions().SetPrecision(parsePrecision(precision))
+	client := influxdb2.NewClientWithOptions(url, token, options)
+
+	return &v2Backend{
+		client:   client,
+		writeAPI: client.WriteAPIBlocking(org, bucket),
 	}
+}
 
-	// check connection
-	go func(client influxdb.Client) {
-		if _, _, err := client.Ping(writeTimeout); err != nil {
-			log.Fatalf("influx: %s", err)
+// parsePrecision maps an influxdb1-client-style precision string ("ns", "us",
+// "ms", "s") to the time.Duration the v2 client API expects, defaulting to
+// nanosecond precision for an empty or unrecognized value
+func parsePrecision(precision string) time.Duration {
+	switch precision {
+	case "ns":
+		return time.Nanosecond
+	case "us":
+		return time.Microsecond
+	case "ms":
+		return time.Millisecond
+	case "s":
+		return time.Second
+	default:
+		return time.Nanosecond
+	}
+}
+
+func (b *v2Backend) WritePoints(points []*influxdb.Point) error {
+	converted := make([]*influxdb2write.Point, 0, len(points))
+	for _, p := range points {
+		fields, err := p.Fields()
+		if err != nil {
+			log.Printf("influx: error reading point fields: %v", err)
+			continue
 		}
-	}(client)
 
-	return &Influx{
-		client: client,
-		pointsConf: influxdb.BatchPointsConfig{
-			Database:  database,
-			Precision: precision,
-		},
-		interval:    interval,
-		measurement: measurement,
-		verbose:     verbose,
+		converted = append(converted, influxdb2.NewPoint(p.Name(), p.Tags(), fields, p.Time()))
 	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+	defer cancel()
+
+	return b.writeAPI.WritePoint(ctx, converted...)
+}
+
+func (b *v2Backend) Ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+	defer cancel()
+
+	ok, err := b.client.Ping(ctx)
+	if err == nil && !ok {
+		return errPingFailed
+	}
+	return err
+}
+
+func (b *v2Backend) Close() error {
+	b.client.Close()
+	return nil
+}
+
+// SetPointExpiry configures how long a point may sit in the retry queue
+// before it is considered stale and dropped
+func (m *Influx) SetPointExpiry(expiry time.Duration) {
+	m.pointExpiry = expiry
+}
+
+// SetMaxQueueSize configures the upper bound on the number of points kept
+// for retry before the oldest ones are dropped
+func (m *Influx) SetMaxQueueSize(size int) {
+	m.maxQueueSize = size
+}
+
+// SetBatchMaxSize configures how many queued points trigger an immediate
+// flush instead of waiting for the next interval tick, and the largest
+// number of points written to the backend in a single call
+func (m *Influx) SetBatchMaxSize(size int) {
+	m.batchMaxSize = size
+}
+
+// SetTransformer configures the rule pipeline applied to every snip before
+// it is turned into a point
+func (m *Influx) SetTransformer(transformer *Transformer) {
+	m.transformer = transformer
+}
+
+// SetTags configures static tags (e.g. site, location, installation_id) that
+// are merged into every point, for distinguishing sources in a shared bucket
+func (m *Influx) SetTags(tags map[string]string) {
+	m.tags = tags
+}
+
+// parsePhase returns the per-line suffix (L1/L2/L3) of a measurement type
+// string, or "" if it has none, so it can be stored as its own "phase" tag
+// instead of being baked into "type", letting Grafana GROUP BY phase cleanly.
+// It does not strip the suffix; callers trim it from the type themselves.
+func parsePhase(measurementType string) string {
+	for _, phase := range phaseSuffixes {
+		if strings.HasSuffix(measurementType, phase) {
+			return phase
+		}
+	}
+	return ""
+}
+
+// filterExpiredPoints drops points whose timestamp is older than pointExpiry,
+// preventing stale readings from being replayed once Influx recovers from an outage
+func filterExpiredPoints(points []*influxdb.Point, expiry time.Duration) []*influxdb.Point {
+	if expiry <= 0 {
+		return points
+	}
+
+	cutoff := time.Now().Add(-expiry)
+	filtered := points[:0]
+	for _, p := range points {
+		if p.Time().Before(cutoff) {
+			continue
+		}
+		filtered = append(filtered, p)
+	}
+
+	if dropped := len(points) - len(filtered); dropped > 0 {
+		log.Printf("influx: dropped %d expired points", dropped)
+	}
+
+	return filtered
 }
 
 // writeBatchPoints asynchronously writes the collected points to influx
@@ -80,28 +343,82 @@ func (m *Influx) writeBatchPoints() {
 		return
 	}
 
-	// create new batch
-	batch, err := influxdb.NewBatchPoints(m.pointsConf)
-	if err != nil {
-		log.Printf("influx: error creating batch: %v", err)
-		m.Unlock()
-		return
-	}
-
 	// replace current batch
 	points := m.points
 	m.points = nil
+	influxQueueDepth.Set(0)
 	m.Unlock()
 
-	// write batch
-	batch.AddPoints(points)
-	if err := m.client.Write(batch); err != nil {
-		log.Printf("influx: failed writing %d points, will retry: %v", len(points), err)
+	// write batch, splitting it so a single call never exceeds batchMaxSize
+	var failed []*influxdb.Point
+	for _, chunk := range chunkPoints(points, m.batchMaxSize) {
+		if err := m.backend.WritePoints(chunk); err != nil {
+			log.Printf("influx: failed writing %d points, will retry: %v", len(chunk), err)
+			influxWriteFailures.Inc()
+			failed = append(failed, chunk...)
+			continue
+		}
+		influxPointsWritten.Add(float64(len(chunk)))
+	}
 
-		// put points back at beginning of next batch
-		m.Lock()
-		m.points = append(points, m.points...)
-		m.Unlock()
+	if len(failed) == 0 {
+		return
+	}
+
+	// drop points that are too old to be meaningful by the time they're retried
+	failed = filterExpiredPoints(failed, m.pointExpiry)
+
+	// put points back at beginning of next batch
+	m.Lock()
+	m.points = append(failed, m.points...)
+	m.enforceMaxQueueSize()
+	m.Unlock()
+}
+
+// chunkPoints splits points into slices of at most size points each, so a
+// single backend write never exceeds Influx's request size limit
+func chunkPoints(points []*influxdb.Point, size int) [][]*influxdb.Point {
+	if size <= 0 || len(points) <= size {
+		return [][]*influxdb.Point{points}
+	}
+
+	chunks := make([][]*influxdb.Point, 0, (len(points)+size-1)/size)
+	for len(points) > 0 {
+		n := size
+		if n > len(points) {
+			n = len(points)
+		}
+		chunks = append(chunks, points[:n])
+		points = points[n:]
+	}
+	return chunks
+}
+
+// enforceMaxQueueSize drops the oldest queued points once the retry queue
+// exceeds maxQueueSize, so a prolonged Influx outage cannot grow m.points
+// without bound. Must be called with m locked.
+func (m *Influx) enforceMaxQueueSize() {
+	if m.maxQueueSize > 0 && len(m.points) > m.maxQueueSize {
+		dropped := len(m.points) - m.maxQueueSize
+		m.points = m.points[dropped:]
+		log.Printf("influx: queue exceeded %d points, dropped %d oldest", m.maxQueueSize, dropped)
+	}
+
+	influxQueueDepth.Set(float64(len(m.points)))
+	m.signalFlushIfFull()
+}
+
+// signalFlushIfFull wakes the async writer immediately once the queue has
+// crossed batchMaxSize, instead of waiting for the next interval tick.
+// Must be called with m locked.
+func (m *Influx) signalFlushIfFull() {
+	if m.batchMaxSize <= 0 || len(m.points) < m.batchMaxSize {
+		return
+	}
+
+	select {
+	case m.flush <- struct{}{}:
+	default:
 	}
 }
 
@@ -116,6 +433,8 @@ func (m *Influx) asyncWriter(exit <-chan bool) <-chan bool {
 			select {
 			case <-ticker.C:
 				m.writeBatchPoints()
+			case <-m.flush:
+				m.writeBatchPoints()
 			case <-exit:
 				ticker.Stop()
 				m.writeBatchPoints()
@@ -135,23 +454,39 @@ func (m *Influx) Run(in <-chan QuerySnip) {
 	done := m.asyncWriter(exit) // done signals writer stopped
 
 	for snip := range in {
+		snip = m.transformer.Apply(snip)
+
+		measurementType := snip.Measurement.String()
+		tags := map[string]string{
+			"device": snip.Device,
+		}
+		if phase := parsePhase(measurementType); phase != "" {
+			tags["phase"] = phase
+			measurementType = strings.TrimSuffix(measurementType, phase)
+		}
+		tags["type"] = measurementType
+		for k, v := range m.tags {
+			if _, reserved := tags[k]; reserved {
+				log.Printf("influx: ignoring static tag %q: collides with a derived tag", k)
+				continue
+			}
+			tags[k] = v
+		}
+
 		p, err := influxdb.NewPoint(
 			m.measurement,
-			map[string]string{
-				"device": getDevice(snip.Device),
-				"type":   snip.Measurement.String(),
-			},
-			map[string]interface{}{"value": getValue(snip.Device, snip.Measurement.String(), snip.Value)},
+			tags,
+			map[string]interface{}{"value": snip.Value},
 			snip.Timestamp,
 		)
 		if err != nil {
 			log.Printf("influx: error creating point: %v", err)
 			continue
 		}
-		//log.Printf("Influx new Point: Device %s, Type: %s, Value: %.3f", getDevice(snip.Device), snip.Measurement.String(), getValue(snip.Device, snip.Measurement.String(), snip.Value))
-		
+
 		m.Lock()
 		m.points = append(m.points, p)
+		m.enforceMaxQueueSize()
 		m.Unlock()
 	}
 
@@ -159,26 +494,5 @@ func (m *Influx) Run(in <-chan QuerySnip) {
 	exit <- true
 	<-done
 
-	m.client.Close()
+	m.backend.Close()
 }
-
-func getDevice(device string ) string {
-	switch device {
-		case "SDM2301.1":
-			return "SDM1.1"		
-		case "SDM2301.2":
-			return "SDM1.2"
-	}
-	return device	
-}
-
-func getValue(device string, valueType string, value float64 ) float64 {
-	switch device {
-		case "SDM2301.2":
-			switch valueType {
-				case "Import":
-					return value + 1000;
-			}
-	}
-	return value	
-}
\ No newline at end of file
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	influxdb "github.com/influxdata/influxdb1-client/v2"
+)
+
+func newTestPoint(t *testing.T, at time.Time) *influxdb.Point {
+	t.Helper()
+
+	p, err := influxdb.NewPoint("test", nil, map[string]interface{}{"value": 1.0}, at)
+	if err != nil {
+		t.Fatalf("failed creating test point: %v", err)
+	}
+	return p
+}
+
+func TestFilterExpiredPoints(t *testing.T) {
+	now := time.Now()
+	fresh := newTestPoint(t, now)
+	stale := newTestPoint(t, now.Add(-time.Hour))
+
+	got := filterExpiredPoints([]*influxdb.Point{stale, fresh}, 10*time.Minute)
+
+	if len(got) != 1 || got[0] != fresh {
+		t.Errorf("expected only the fresh point to survive, got %v", got)
+	}
+}
+
+func TestFilterExpiredPointsZeroExpiryDisabled(t *testing.T) {
+	points := []*influxdb.Point{newTestPoint(t, time.Now().Add(-time.Hour))}
+
+	got := filterExpiredPoints(points, 0)
+
+	if len(got) != 1 {
+		t.Errorf("expected expiry<=0 to be a no-op, got %v", got)
+	}
+}
+
+func TestEnforceMaxQueueSizeDropsOldest(t *testing.T) {
+	now := time.Now()
+	oldest := newTestPoint(t, now.Add(-2*time.Second))
+	middle := newTestPoint(t, now.Add(-1*time.Second))
+	newest := newTestPoint(t, now)
+
+	m := &Influx{
+		maxQueueSize: 2,
+		batchMaxSize: 0,
+		flush:        make(chan struct{}, 1),
+		points:       []*influxdb.Point{oldest, middle, newest},
+	}
+
+	m.enforceMaxQueueSize()
+
+	if len(m.points) != 2 || m.points[0] != middle || m.points[1] != newest {
+		t.Errorf("expected the oldest point to be dropped, got %v", m.points)
+	}
+}
+
+func TestEnforceMaxQueueSizeUnboundedWhenZero(t *testing.T) {
+	m := &Influx{
+		maxQueueSize: 0,
+		flush:        make(chan struct{}, 1),
+		points:       []*influxdb.Point{newTestPoint(t, time.Now())},
+	}
+
+	m.enforceMaxQueueSize()
+
+	if len(m.points) != 1 {
+		t.Errorf("expected maxQueueSize<=0 to be unbounded, got %d points", len(m.points))
+	}
+}
+
@@ -0,0 +1,24 @@
+package server
+
+import "testing"
+
+func TestParsePhase(t *testing.T) {
+	tests := []struct {
+		measurementType string
+		want            string
+	}{
+		{"PowerL1", "L1"},
+		{"PowerL2", "L2"},
+		{"PowerL3", "L3"},
+		{"Power", ""},
+		{"VoltageL1N", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.measurementType, func(t *testing.T) {
+			if got := parsePhase(tt.measurementType); got != tt.want {
+				t.Errorf("parsePhase(%q) = %q, want %q", tt.measurementType, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package server
+
+// TransformMatch selects which snips a TransformRule applies to. An empty
+// field matches any value, so a rule can target a single device, a single
+// measurement, or the combination of both.
+type TransformMatch struct {
+	Device      string `yaml:"device"`
+	Measurement string `yaml:"measurement"`
+}
+
+// TransformRule rewrites the device name and/or value of a matching QuerySnip.
+// This replaces what used to be hardcoded per-site renames and offsets in
+// getDevice/getValue, letting operators handle meter replacement, aliasing
+// and unit conversion through config instead of a recompile.
+type TransformRule struct {
+	Match        TransformMatch `yaml:"match"`
+	RenameDevice string         `yaml:"rename_device"`
+	Offset       float64        `yaml:"offset"`
+	// Scale is a pointer so an explicit `scale: 0` (zero out the value) can
+	// be told apart from an omitted field (leave the value unscaled)
+	Scale *float64 `yaml:"scale"`
+}
+
+func (r TransformRule) matches(snip QuerySnip) bool {
+	if r.Match.Device != "" && r.Match.Device != snip.Device {
+		return false
+	}
+	if r.Match.Measurement != "" && r.Match.Measurement != snip.Measurement.String() {
+		return false
+	}
+	return true
+}
+
+// apply returns snip with this rule's rename/offset/scale applied
+func (r TransformRule) apply(snip QuerySnip) QuerySnip {
+	if r.RenameDevice != "" {
+		snip.Device = r.RenameDevice
+	}
+
+	scale := 1.0
+	if r.Scale != nil {
+		scale = *r.Scale
+	}
+	snip.Value = snip.Value*scale + r.Offset
+
+	return snip
+}
+
+// Transformer applies a configured set of TransformRules to every QuerySnip
+// before it is handed to a publisher (Influx, MQTT, HTTP, ...). Only the
+// first matching rule is applied.
+type Transformer struct {
+	rules []TransformRule
+}
+
+// NewTransformer creates a Transformer from rules loaded from config
+func NewTransformer(rules []TransformRule) *Transformer {
+	return &Transformer{rules: rules}
+}
+
+// Apply rewrites snip according to the first rule that matches it, or
+// returns snip unchanged if no rule matches
+func (t *Transformer) Apply(snip QuerySnip) QuerySnip {
+	if t == nil {
+		return snip
+	}
+
+	for _, rule := range t.rules {
+		if rule.matches(snip) {
+			return rule.apply(snip)
+		}
+	}
+
+	return snip
+}
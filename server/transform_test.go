@@ -0,0 +1,104 @@
+package server
+
+import "testing"
+
+func scalePtr(v float64) *float64 { return &v }
+
+func TestTransformRuleMatchesDevice(t *testing.T) {
+	rule := TransformRule{Match: TransformMatch{Device: "SDM2301.2"}}
+
+	if !rule.matches(QuerySnip{Device: "SDM2301.2"}) {
+		t.Error("expected rule to match snip with the same device")
+	}
+	if rule.matches(QuerySnip{Device: "SDM2301.1"}) {
+		t.Error("expected rule not to match a different device")
+	}
+}
+
+func TestTransformRuleMatchesEmptyFieldsMatchAny(t *testing.T) {
+	rule := TransformRule{}
+
+	if !rule.matches(QuerySnip{Device: "anything"}) {
+		t.Error("expected a rule with no match criteria to match any snip")
+	}
+}
+
+func TestTransformRuleApply(t *testing.T) {
+	tests := []struct {
+		name string
+		rule TransformRule
+		in   QuerySnip
+		want QuerySnip
+	}{
+		{
+			name: "rename device",
+			rule: TransformRule{RenameDevice: "SDM1.2"},
+			in:   QuerySnip{Device: "SDM2301.2", Value: 10},
+			want: QuerySnip{Device: "SDM1.2", Value: 10},
+		},
+		{
+			name: "offset only",
+			rule: TransformRule{Offset: 1000},
+			in:   QuerySnip{Device: "SDM2301.2", Value: 10},
+			want: QuerySnip{Device: "SDM2301.2", Value: 1010},
+		},
+		{
+			name: "unset scale defaults to 1",
+			rule: TransformRule{Offset: 5},
+			in:   QuerySnip{Value: 10},
+			want: QuerySnip{Value: 15},
+		},
+		{
+			name: "explicit scale 0 zeroes the value",
+			rule: TransformRule{Scale: scalePtr(0), Offset: 5},
+			in:   QuerySnip{Value: 10},
+			want: QuerySnip{Value: 5},
+		},
+		{
+			name: "scale and offset combined",
+			rule: TransformRule{Scale: scalePtr(2), Offset: 1},
+			in:   QuerySnip{Value: 10},
+			want: QuerySnip{Value: 21},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rule.apply(tt.in); got != tt.want {
+				t.Errorf("apply(%+v) = %+v, want %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransformerApplyFirstMatchOnly(t *testing.T) {
+	transformer := NewTransformer([]TransformRule{
+		{Match: TransformMatch{Device: "SDM2301.2"}, Offset: 1000},
+		{Offset: 1},
+	})
+
+	got := transformer.Apply(QuerySnip{Device: "SDM2301.2", Value: 10})
+	if got.Value != 1010 {
+		t.Errorf("expected only the first matching rule to apply, got value %v", got.Value)
+	}
+}
+
+func TestTransformerApplyNoMatchLeavesSnipUnchanged(t *testing.T) {
+	transformer := NewTransformer([]TransformRule{
+		{Match: TransformMatch{Device: "other"}, Offset: 1000},
+	})
+
+	snip := QuerySnip{Device: "SDM2301.2", Value: 10}
+	if got := transformer.Apply(snip); got != snip {
+		t.Errorf("expected unchanged snip, got %+v", got)
+	}
+}
+
+func TestTransformerApplyNilIsNoOp(t *testing.T) {
+	var transformer *Transformer
+
+	snip := QuerySnip{Device: "SDM2301.2", Value: 10}
+	if got := transformer.Apply(snip); got != snip {
+		t.Errorf("expected unchanged snip from nil transformer, got %+v", got)
+	}
+}